@@ -0,0 +1,218 @@
+package nimby
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/jmanero/nimby/logging"
+	"github.com/jmanero/nimby/metrics"
+	"go.uber.org/zap"
+)
+
+// DomainSummary describes a registered domain for the admin API's /domains listing
+type DomainSummary struct {
+	Host      string `json:"host"`
+	Strategy  string `json:"strategy"`
+	Upstreams int    `json:"upstreams"`
+	Weight    uint64 `json:"weight"`
+}
+
+// UpstreamSummary describes a single upstream for the admin API's
+// /domains/{host} detail view
+type UpstreamSummary struct {
+	ID       string `json:"id"`
+	JobID    string `json:"jobId"`
+	AllocID  string `json:"allocId"`
+	Endpoint string `json:"endpoint"`
+	Weight   uint64 `json:"weight"`
+	InFlight int64  `json:"inFlight"`
+	Healthy  bool   `json:"healthy"`
+}
+
+// DomainDetail describes a single domain's upstreams for the admin API's
+// /domains/{host} endpoint
+type DomainDetail struct {
+	Host      string            `json:"host"`
+	Strategy  string            `json:"strategy"`
+	Upstreams []UpstreamSummary `json:"upstreams"`
+}
+
+// NewAdminHandler builds the admin/status API's http.Handler, exposing
+// operational endpoints that aren't reachable via the proxy vhost. metrics
+// serves the Prometheus /metrics endpoint, built by the caller from
+// metrics.NewRegistry(controller.Snapshot) to keep this package free of a
+// direct dependency on the promhttp exporter.
+func NewAdminHandler(controller *Controller, metrics http.Handler) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /healthz", handleHealthz)
+	mux.HandleFunc("GET /readyz", handleReadyz)
+	mux.Handle("GET /metrics", metrics)
+
+	mux.HandleFunc("GET /domains", controller.handleDomains)
+	mux.HandleFunc("GET /domains/{host}", controller.handleDomain)
+	mux.HandleFunc("POST /domains/{host}/drain", controller.handleDrain)
+
+	mux.HandleFunc("POST /token/reload", controller.handleTokenReload)
+
+	return mux
+}
+
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok"))
+}
+
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok"))
+}
+
+func (controller *Controller) handleDomains(w http.ResponseWriter, r *http.Request) {
+	domains := controller.Domains()
+	summaries := make([]DomainSummary, 0, len(domains))
+
+	for host, balancer := range domains {
+		summaries = append(summaries, summarizeDomain(host, balancer))
+	}
+
+	writeJSON(w, summaries)
+}
+
+func (controller *Controller) handleDomain(w http.ResponseWriter, r *http.Request) {
+	host := r.PathValue("host")
+
+	balancer, has := controller.Get(host)
+	if !has {
+		http.NotFound(w, r)
+		return
+	}
+
+	strategy, backends := InspectDomain(balancer)
+	detail := DomainDetail{Host: host, Strategy: strategy, Upstreams: make([]UpstreamSummary, 0, len(backends))}
+
+	for _, backend := range backends {
+		detail.Upstreams = append(detail.Upstreams, UpstreamSummary{
+			ID:       backend.ID,
+			JobID:    backend.JobID,
+			AllocID:  backend.AllocID,
+			Endpoint: backend.Endpoint.String(),
+			Weight:   backend.Weight,
+			InFlight: backend.InFlight(),
+			Healthy:  backend.Healthy(),
+		})
+	}
+
+	writeJSON(w, detail)
+}
+
+func (controller *Controller) handleDrain(w http.ResponseWriter, r *http.Request) {
+	host := r.PathValue("host")
+
+	if !controller.Drain(host) {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (controller *Controller) handleTokenReload(w http.ResponseWriter, r *http.Request) {
+	if err := controller.LoadToken(); err != nil {
+		logging.Error(r.Context(), "token.error", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// unwrapDraining peels drainingHandler and mwHandler off a Handler to reach
+// the underlying Router or Balancer it wraps, if any
+func unwrapDraining(handler Handler) Handler {
+	for {
+		switch wrapped := handler.(type) {
+		case drainingHandler:
+			handler = wrapped.Handler
+		case mwHandler:
+			handler = wrapped.Handler
+		default:
+			return handler
+		}
+	}
+}
+
+// InspectDomain extracts a domain's strategy name and upstream snapshot from
+// its Handler. A plain Balancer reports its own name and upstreams; a Router
+// reports the first rule's strategy name alongside every rule's upstreams
+// combined. It's exported so the metrics package's gauge Collector can reuse
+// it via Controller.Snapshot.
+func InspectDomain(handler Handler) (strategy string, backends []*WeightedUpstream) {
+	handler = unwrapDraining(handler)
+
+	router, ok := handler.(*Router)
+	if !ok {
+		return inspectBalancer(handler)
+	}
+
+	for _, rule := range router.rules {
+		name, upstreams := inspectBalancer(unwrapDraining(rule.handler))
+		if strategy == "" {
+			strategy = name
+		}
+
+		backends = append(backends, upstreams...)
+	}
+
+	return
+}
+
+func inspectBalancer(handler Handler) (strategy string, backends []*WeightedUpstream) {
+	balancer, ok := handler.(Balancer)
+	if !ok {
+		return
+	}
+
+	return balancer.Name, balancer.Snapshot()
+}
+
+func summarizeDomain(host string, handler Handler) DomainSummary {
+	summary := DomainSummary{Host: host}
+
+	strategy, backends := InspectDomain(handler)
+	summary.Strategy = strategy
+
+	for _, backend := range backends {
+		summary.Upstreams++
+		summary.Weight += backend.Weight
+	}
+
+	return summary
+}
+
+// Snapshot builds a metrics.DomainSnapshot for every domain currently
+// registered, for metrics.NewRegistry's gauge Collector
+func (controller *Controller) Snapshot() []metrics.DomainSnapshot {
+	domains := controller.Domains()
+	snapshot := make([]metrics.DomainSnapshot, 0, len(domains))
+
+	for host, handler := range domains {
+		_, backends := InspectDomain(handler)
+		upstreams := make([]metrics.UpstreamSnapshot, 0, len(backends))
+
+		for _, backend := range backends {
+			upstreams = append(upstreams, metrics.UpstreamSnapshot{
+				ID:       backend.ID,
+				InFlight: backend.InFlight(),
+				Healthy:  backend.Healthy(),
+			})
+		}
+
+		snapshot = append(snapshot, metrics.DomainSnapshot{Host: host, Upstreams: upstreams})
+	}
+
+	return snapshot
+}
+
+func writeJSON(w http.ResponseWriter, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(value)
+}
@@ -3,16 +3,47 @@ package nimby
 import (
 	"context"
 	"crypto/rand"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"net/http/httputil"
 	"net/url"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/nomad/api"
 	"github.com/jmanero/nimby/logging"
+	"github.com/jmanero/nimby/metrics"
 	"go.uber.org/zap"
 )
 
+// Transport is the shared RoundTripper used by every WeightedUpstream's
+// ReverseProxy. Idle connection lifetime is configurable so operators can
+// tune it for the churn of Nomad-scheduled backends.
+var Transport = &http.Transport{
+	Proxy:                 nil,
+	ForceAttemptHTTP2:     true,
+	MaxIdleConns:          100,
+	IdleConnTimeout:       EnvDuration("NIMBY_UPSTREAM_IDLE_TIMEOUT", 90*time.Second),
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: time.Second,
+	ResponseHeaderTimeout: EnvDuration("NIMBY_UPSTREAM_HEADER_TIMEOUT", 0),
+}
+
+// FlushInterval controls how often a streaming upstream response's buffered
+// bytes are flushed to the client. A zero value only flushes once the
+// upstream's body is fully buffered; a negative value flushes immediately
+// after every Write, which is required for low-latency streams like SSE.
+var FlushInterval = EnvDuration("NIMBY_UPSTREAM_FLUSH_INTERVAL", 100*time.Millisecond)
+
+type startTimeKey struct{}
+type domainKey struct{}
+
+// ErrNoUpstreams is returned by a Strategy that has no upstream to select
+var ErrNoUpstreams = errors.New("balancer: no upstreams available")
+
 // WeightedUpstream provides an HTTP upstream for weighted balancer implementations
 type WeightedUpstream struct {
 	ID      string
@@ -21,62 +52,265 @@ type WeightedUpstream struct {
 
 	Weight   uint64
 	Endpoint url.URL
+	Host     string
+
+	proxy    *httputil.ReverseProxy
+	inflight atomic.Int64
+	health   health
+}
+
+// InFlight reports the number of requests currently being proxied to this upstream
+func (backend *WeightedUpstream) InFlight() int64 {
+	return backend.inflight.Load()
 }
 
-func (backend WeightedUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	_, logger := logging.Logger(r.Context())
+// Healthy reports whether the upstream should currently receive traffic: its
+// active checker must consider it up, and it must not be passively ejected
+func (backend *WeightedUpstream) Healthy() bool {
+	return backend.health.healthy()
+}
 
-	r.RequestURI = ""
-	r.URL = backend.Endpoint.JoinPath(r.URL.RawPath)
-	// TODO: Forwarding address headers
+// NewWeightedUpstream builds a WeightedUpstream and its ReverseProxy from a
+// Nomad service registration and starts its active health checker. ctx
+// should outlive the upstream; StopHealthCheck stops the checker when the
+// upstream is removed from its balancer. rehash is called whenever the
+// upstream's health state changes, so its owning Strategy can drop or
+// restore it from rotation without waiting for an Add/Del.
+func NewWeightedUpstream(ctx context.Context, service *api.ServiceRegistration, weight uint64, rehash func(context.Context)) *WeightedUpstream {
+	host, _ := HostTag(service.Tags)
+
+	path, has := HealthPathTag(service.Tags)
+	if !has {
+		path = DefaultHealthPath
+	}
 
-	start := time.Now()
-	logger.Info("upstream.begin", zap.Time("start", start), zap.String("method", r.Method), zap.Stringer("endpoint", r.URL))
+	backend := &WeightedUpstream{
+		ID:      service.ID,
+		JobID:   service.JobID,
+		AllocID: service.AllocID,
 
-	res, err := http.DefaultClient.Do(r)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		Weight:   weight,
+		Endpoint: UpstreamService(service),
+		Host:     host,
 	}
 
-	logger.Info("upstream.response", zap.Time("start", start), zap.Duration("elapsed", time.Since(start)), zap.Int("code", res.StatusCode))
+	backend.proxy = &httputil.ReverseProxy{
+		Transport:     Transport,
+		FlushInterval: FlushInterval,
 
-	headers := w.Header()
-	for name, values := range res.Header {
-		headers[name] = values
+		Rewrite:        backend.rewrite,
+		ModifyResponse: backend.modifyResponse,
+		ErrorHandler:   backend.errorHandler,
 	}
 
-	w.WriteHeader(res.StatusCode)
-	io.Copy(w, res.Body)
-	res.Body.Close()
+	backend.health.init(ctx, backend, path, rehash)
 
-	// Best effort to propagate trailers downstream... Not tested yet.
-	for name, values := range res.Trailer {
-		headers[http.TrailerPrefix+name] = values
+	return backend
+}
+
+// StopHealthCheck stops the upstream's active-check goroutine. Strategies
+// call this when an upstream is removed from their pool.
+func (backend *WeightedUpstream) StopHealthCheck() {
+	backend.health.stop()
+}
+
+// addUpstream returns a copy of upstreams with service inserted as a new
+// WeightedUpstream, wired to call rehash on a health-state transition, or
+// upstreams unchanged if service.ID is already present. It factors the
+// membership bookkeeping shared by every Strategy's Add except
+// WeightedRandom, which also has to fold the new backend's weight into its
+// running total.
+func addUpstream(ctx context.Context, upstreams map[string]*WeightedUpstream, service *api.ServiceRegistration, rehash func(context.Context)) (map[string]*WeightedUpstream, bool) {
+	if _, has := upstreams[service.ID]; has {
+		return upstreams, false
 	}
 
-	logger.Info("upstream.end", zap.Time("start", start), zap.Duration("elapsed", time.Since(start)))
+	weight, _ := WeightTag(service.Tags)
+
+	_, logger := logging.Logger(ctx)
+	logger.Info("upstream.add", zap.String("addr", service.Address), zap.Int("port", service.Port))
+
+	next := make(map[string]*WeightedUpstream, len(upstreams)+1)
+	for id, backend := range upstreams {
+		next[id] = backend
+	}
+	next[service.ID] = NewWeightedUpstream(ctx, service, weight, rehash)
+
+	return next, true
 }
 
-// WeightedRandom implements a simple load-balancer Handler
-type WeightedRandom struct {
-	Upstreams map[string]*WeightedUpstream
-	Total     uint64
+// delUpstream returns a copy of upstreams with service's backend removed and
+// its health checker stopped, or upstreams unchanged if service.ID isn't
+// present. It factors the membership bookkeeping shared by every Strategy's
+// Del except WeightedRandom, which also has to recompute its running weight
+// total.
+func delUpstream(ctx context.Context, upstreams map[string]*WeightedUpstream, service *api.ServiceRegistration) (map[string]*WeightedUpstream, bool) {
+	if _, has := upstreams[service.ID]; !has {
+		return upstreams, false
+	}
 
-	weighted []*WeightedUpstream
-	random   io.Reader
+	_, logger := logging.Logger(ctx)
+	logger.Info("upstream.del", zap.String("addr", service.Address), zap.Int("port", service.Port))
+
+	next := make(map[string]*WeightedUpstream, len(upstreams)-1)
+	for id, backend := range upstreams {
+		if id == service.ID {
+			backend.StopHealthCheck()
+			continue
+		}
+
+		next[id] = backend
+	}
+
+	return next, true
+}
+
+// rewrite implements httputil.ReverseProxy's Rewrite hook, pointing the
+// outbound request at the upstream's endpoint and annotating it with
+// forwarding headers instead of the ad-hoc client.Do it replaces.
+func (backend *WeightedUpstream) rewrite(pr *httputil.ProxyRequest) {
+	target := backend.Endpoint
+
+	// SetURL itself joins target's path with the incoming request's path, so
+	// target carries only the upstream's configured nimby-path: prefix here;
+	// joining it again first would apply the incoming path twice.
+	pr.SetURL(&target)
+	pr.SetXForwarded()
+
+	pr.Out.Host = backend.Host
+	if pr.Out.Host == "" {
+		pr.Out.Host = pr.In.Host
+	}
+
+	pr.Out.Header.Set("Forwarded", forwardedHeader(pr))
+}
+
+// forwardedHeader builds an RFC 7239 `Forwarded` header value, appending to
+// any value already present on the inbound request.
+func forwardedHeader(pr *httputil.ProxyRequest) string {
+	proto := pr.In.URL.Scheme
+	if proto == "" {
+		proto = "http"
+	}
+
+	value := fmt.Sprintf("for=%q;host=%q;proto=%s", pr.In.RemoteAddr, pr.In.Host, proto)
+
+	if prior := pr.In.Header.Get("Forwarded"); prior != "" {
+		return prior + ", " + value
+	}
+
+	return value
+}
+
+func (backend *WeightedUpstream) modifyResponse(res *http.Response) error {
+	ctx := res.Request.Context()
+
+	if ce := logging.Check(ctx, zap.InfoLevel, "upstream.response"); ce != nil {
+		start, _ := ctx.Value(startTimeKey{}).(time.Time)
+		ce.Write(zap.Duration("elapsed", time.Since(start)), zap.Int("code", res.StatusCode))
+	}
+
+	backend.health.recordOutcome(ctx, res.StatusCode < http.StatusInternalServerError)
+
+	domain, _ := ctx.Value(domainKey{}).(string)
+	metrics.UpstreamRequestsTotal.WithLabelValues(domain, backend.ID, strconv.Itoa(res.StatusCode)).Inc()
+
+	return nil
+}
+
+func (backend *WeightedUpstream) errorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	logging.Warn(r.Context(), "upstream.error", zap.Error(err), zap.Stringer("endpoint", &backend.Endpoint))
+	backend.health.recordOutcome(r.Context(), false)
+
+	domain, _ := r.Context().Value(domainKey{}).(string)
+	metrics.UpstreamRequestsTotal.WithLabelValues(domain, backend.ID, strconv.Itoa(http.StatusBadGateway)).Inc()
+
+	http.Error(w, "Bad Gateway", http.StatusBadGateway)
+}
+
+func (backend *WeightedUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithValue(r.Context(), startTimeKey{}, time.Now())
+	ctx = context.WithValue(ctx, domainKey{}, r.Host)
+
+	if ce := logging.Check(ctx, zap.InfoLevel, "upstream.begin"); ce != nil {
+		ce.Write(zap.String("method", r.Method), zap.Stringer("endpoint", &backend.Endpoint))
+	}
+
+	backend.inflight.Add(1)
+	defer backend.inflight.Add(-1)
 
-	notEmpty
+	backend.proxy.ServeHTTP(w, r.WithContext(ctx))
 }
 
-// NewBalancer creates a new weighted-random load balancer Handler
-func NewBalancer(_ []string) Handler {
-	// TODO: switch balancer strategies from a tag.
-	return WeightedRandom{random: rand.Reader}
+// randomUint64 reads a uniformly distributed uint64 from a random source,
+// shared by the strategies that need to make a random selection
+func randomUint64(random io.Reader) (val uint64, err error) {
+	var buf [8]byte
+
+	_, err = random.Read(buf[:])
+	if err != nil {
+		return
+	}
+
+	for _, b := range buf {
+		val = val<<8 | uint64(b)
+	}
+
+	return
+}
+
+// Strategy selects upstreams for a domain's balancer and maintains its own
+// membership bookkeeping. Implementations are registered in Strategies and
+// chosen per-service via the `nimby-strategy:` tag.
+type Strategy interface {
+	Next(r *http.Request) (*WeightedUpstream, error)
+	Add(ctx context.Context, service *api.ServiceRegistration) Strategy
+	Del(ctx context.Context, service *api.ServiceRegistration) Strategy
+	Rehash(ctx context.Context)
+	Empty() bool
+
+	// Snapshot returns every upstream currently known to the Strategy,
+	// regardless of health, for the admin API
+	Snapshot() []*WeightedUpstream
+}
+
+// Strategies is the registry of balancer-strategy constructors, keyed by the
+// value of a service's `nimby-strategy:` tag
+var Strategies = map[string]func() Strategy{
+	"weighted-random": func() Strategy { return &WeightedRandom{random: rand.Reader} },
+	"round-robin":     func() Strategy { return &RoundRobin{} },
+	"least-conn":      func() Strategy { return &LeastConn{} },
+	"p2c":             func() Strategy { return &P2C{random: rand.Reader} },
+	"consistent-hash": func() Strategy { return &ConsistentHash{} },
 }
 
-func (balancer WeightedRandom) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	upstream, err := balancer.Next()
+// DefaultStrategy names the Strategies entry used when a service carries no
+// `nimby-strategy:` tag, or names one that isn't registered
+const DefaultStrategy = "weighted-random"
+
+// Balancer adapts a Strategy to the Controller's Handler interface, keeping
+// the resolved Strategies key alongside it for the admin API
+type Balancer struct {
+	Strategy
+	Name string
+}
+
+// NewBalancer creates a new load-balancer Handler, selecting its Strategy
+// implementation from the service's `nimby-strategy:` tag
+func NewBalancer(tags []string) Handler {
+	name, has := StrategyTag(tags)
+
+	ctor, registered := Strategies[name]
+	if !has || !registered {
+		name = DefaultStrategy
+		ctor = Strategies[DefaultStrategy]
+	}
+
+	return Balancer{Strategy: ctor(), Name: name}
+}
+
+func (balancer Balancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	upstream, err := balancer.Next(r)
 	if err != nil {
 		logging.Warn(r.Context(), "balancer.error", zap.Error(err))
 		http.Error(w, "Unhandled Error", http.StatusInternalServerError)
@@ -93,45 +327,115 @@ func (balancer WeightedRandom) ServeHTTP(w http.ResponseWriter, r *http.Request)
 	logger.Info("request.end")
 }
 
+// Add preserves the Balancer's Strategy, delegating membership changes to it
+func (balancer Balancer) Add(ctx context.Context, service *api.ServiceRegistration) Handler {
+	return Balancer{Strategy: balancer.Strategy.Add(ctx, service), Name: balancer.Name}
+}
+
+// Del preserves the Balancer's Strategy, delegating membership changes to it
+func (balancer Balancer) Del(ctx context.Context, service *api.ServiceRegistration) Handler {
+	next := balancer.Strategy.Del(ctx, service)
+	if next.Empty() {
+		return empty{}
+	}
+
+	return Balancer{Strategy: next, Name: balancer.Name}
+}
+
+// emptyStrategy is the Strategy-level analogue of empty: a sentinel returned
+// once a Strategy's last upstream is removed
+type emptyStrategy struct{}
+
+func (emptyStrategy) Next(*http.Request) (*WeightedUpstream, error) {
+	return nil, ErrNoUpstreams
+}
+
+func (emptyStrategy) Add(ctx context.Context, service *api.ServiceRegistration) Strategy {
+	name, _ := StrategyTag(service.Tags)
+
+	ctor, has := Strategies[name]
+	if !has {
+		ctor = Strategies[DefaultStrategy]
+	}
+
+	return ctor().Add(ctx, service)
+}
+
+func (e emptyStrategy) Del(context.Context, *api.ServiceRegistration) Strategy { return e }
+
+func (emptyStrategy) Rehash(context.Context) {}
+
+func (emptyStrategy) Empty() bool { return true }
+
+func (emptyStrategy) Snapshot() []*WeightedUpstream { return nil }
+
+// notEmptyStrategy is embedded by Strategy implementations that never empty
+// themselves out directly; they hand back emptyStrategy once their last
+// upstream is removed
+type notEmptyStrategy struct{}
+
+func (notEmptyStrategy) Empty() bool { return false }
+
+// WeightedRandom implements a weighted-random Strategy
+type WeightedRandom struct {
+	Upstreams map[string]*WeightedUpstream
+	Total     uint64
+
+	weighted []*WeightedUpstream
+	random   io.Reader
+
+	notEmptyStrategy
+}
+
 // Next selects an upstream to use for a request
-func (balancer WeightedRandom) Next() (upstream *WeightedUpstream, err error) {
-	var buf [8]byte
+func (balancer *WeightedRandom) Next(*http.Request) (upstream *WeightedUpstream, err error) {
+	if len(balancer.weighted) == 0 {
+		return nil, ErrNoUpstreams
+	}
 
-	_, err = balancer.random.Read(buf[:])
+	val, err := randomUint64(balancer.random)
 	if err != nil {
 		return
 	}
 
-	val := uint64(buf[7])
-	val += uint64(buf[6]) << 8
-	val += uint64(buf[5]) << 16
-	val += uint64(buf[4]) << 24
-	val += uint64(buf[3]) << 32
-	val += uint64(buf[2]) << 40
-	val += uint64(buf[1]) << 48
-	val += uint64(buf[0]) << 56
-
-	val = val % balancer.Total
+	val = val % uint64(len(balancer.weighted))
 	return balancer.weighted[val], nil
 }
 
-// Rehash rebuilds the balancer's weighted lookup table
+// Snapshot returns every upstream currently known to the balancer,
+// regardless of health, for the admin API
+func (balancer *WeightedRandom) Snapshot() []*WeightedUpstream {
+	upstreams := make([]*WeightedUpstream, 0, len(balancer.Upstreams))
+	for _, backend := range balancer.Upstreams {
+		upstreams = append(upstreams, backend)
+	}
+
+	return upstreams
+}
+
+// Rehash rebuilds the balancer's weighted lookup table, excluding any
+// upstream that is currently unhealthy
 func (balancer *WeightedRandom) Rehash(ctx context.Context) {
 	weighted := make([]*WeightedUpstream, 0, balancer.Total)
+	healthy := 0
 
-	// Rehash the backend services by their relative weights
 	for _, backend := range balancer.Upstreams {
+		if !backend.Healthy() {
+			continue
+		}
+
+		healthy++
 		for i := uint64(0); i < backend.Weight; i++ {
 			weighted = append(weighted, backend)
 		}
 	}
 
 	balancer.weighted = weighted
-	logging.Info(ctx, "balancer.rehash", zap.Int("count", len(balancer.Upstreams)), zap.Uint64("weight", balancer.Total))
+	logging.Info(ctx, "balancer.rehash", zap.Int("count", len(balancer.Upstreams)), zap.Int("healthy", healthy), zap.Uint64("weight", balancer.Total))
 }
 
 // Add inserts a backend and rehashes the balancer's internal weighting
-func (balancer WeightedRandom) Add(ctx context.Context, service *api.ServiceRegistration) Handler {
+func (balancer *WeightedRandom) Add(ctx context.Context, service *api.ServiceRegistration) Strategy {
 	if _, has := balancer.Upstreams[service.ID]; has {
 		// NOP if the service is already included in the balancer
 		return balancer
@@ -146,14 +450,7 @@ func (balancer WeightedRandom) Add(ctx context.Context, service *api.ServiceRegi
 	backends := make(map[string]*WeightedUpstream, len(balancer.Upstreams)+1)
 
 	// Add the new backend to the balancer's map
-	backends[service.ID] = &WeightedUpstream{
-		ID:      service.ID,
-		JobID:   service.JobID,
-		AllocID: service.AllocID,
-
-		Weight:   weight,
-		Endpoint: UpstreamService(service),
-	}
+	backends[service.ID] = NewWeightedUpstream(ctx, service, weight, balancer.Rehash)
 
 	balancer.Total = weight
 
@@ -169,7 +466,7 @@ func (balancer WeightedRandom) Add(ctx context.Context, service *api.ServiceRegi
 }
 
 // Del removes a backend and rehashes the balancer's internal weighting
-func (balancer WeightedRandom) Del(ctx context.Context, service *api.ServiceRegistration) Handler {
+func (balancer *WeightedRandom) Del(ctx context.Context, service *api.ServiceRegistration) Strategy {
 	if _, has := balancer.Upstreams[service.ID]; !has {
 		// NOP if the service isn't in the balancer
 		return balancer
@@ -187,6 +484,7 @@ func (balancer WeightedRandom) Del(ctx context.Context, service *api.ServiceRegi
 	for id, backend := range balancer.Upstreams {
 		if id == service.ID {
 			// Remove the requested backend
+			backend.StopHealthCheck()
 			continue
 		}
 
@@ -195,7 +493,7 @@ func (balancer WeightedRandom) Del(ctx context.Context, service *api.ServiceRegi
 	}
 
 	if len(backends) == 0 {
-		return empty{}
+		return emptyStrategy{}
 	}
 
 	balancer.Upstreams = backends
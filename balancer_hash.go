@@ -0,0 +1,138 @@
+package nimby
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/hashicorp/nomad/api"
+	"github.com/jmanero/nimby/logging"
+	"go.uber.org/zap"
+)
+
+// VirtualNodesPerWeight is the number of points placed on a ConsistentHash's
+// ring for each unit of an upstream's weight
+const VirtualNodesPerWeight = 100
+
+// DefaultHashHeader names the request header used to key a ConsistentHash
+// strategy when a service carries no `nimby-hash:` tag
+const DefaultHashHeader = "X-Session-Id"
+
+type hashRingEntry struct {
+	hash     uint64
+	upstream *WeightedUpstream
+}
+
+// ConsistentHash implements a consistent-hashing Strategy: requests that
+// share a key (from the `nimby-hash:` header) are routed to the same
+// upstream across Add/Del membership changes, so long-lived client sessions
+// keep sticking to the same backend
+type ConsistentHash struct {
+	Upstreams map[string]*WeightedUpstream
+	Header    string
+
+	ring []hashRingEntry
+
+	notEmptyStrategy
+}
+
+// Next hashes the request's key header onto the ring and returns the
+// upstream owning the next point clockwise from it
+func (balancer *ConsistentHash) Next(r *http.Request) (*WeightedUpstream, error) {
+	if len(balancer.ring) == 0 {
+		return nil, ErrNoUpstreams
+	}
+
+	header := balancer.Header
+	if header == "" {
+		header = DefaultHashHeader
+	}
+
+	key := r.Header.Get(header)
+	if key == "" {
+		key = r.RemoteAddr
+	}
+
+	hash := xxhash.Sum64String(key)
+	idx := sort.Search(len(balancer.ring), func(i int) bool { return balancer.ring[i].hash >= hash })
+	if idx == len(balancer.ring) {
+		idx = 0
+	}
+
+	return balancer.ring[idx].upstream, nil
+}
+
+// Snapshot returns every upstream currently known to the balancer,
+// regardless of health, for the admin API
+func (balancer *ConsistentHash) Snapshot() []*WeightedUpstream {
+	upstreams := make([]*WeightedUpstream, 0, len(balancer.Upstreams))
+	for _, backend := range balancer.Upstreams {
+		upstreams = append(upstreams, backend)
+	}
+
+	return upstreams
+}
+
+// Rehash rebuilds the balancer's sorted hash ring, excluding any upstream
+// that is currently unhealthy
+func (balancer *ConsistentHash) Rehash(ctx context.Context) {
+	ring := make([]hashRingEntry, 0, len(balancer.Upstreams)*VirtualNodesPerWeight)
+	healthy := 0
+
+	for id, backend := range balancer.Upstreams {
+		if !backend.Healthy() {
+			continue
+		}
+
+		healthy++
+		nodes := int(backend.Weight) * VirtualNodesPerWeight
+
+		for i := 0; i < nodes; i++ {
+			ring = append(ring, hashRingEntry{
+				hash:     xxhash.Sum64String(id + "#" + strconv.Itoa(i)),
+				upstream: backend,
+			})
+		}
+	}
+
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+
+	balancer.ring = ring
+	logging.Info(ctx, "balancer.rehash", zap.Int("count", len(balancer.Upstreams)), zap.Int("healthy", healthy), zap.Int("nodes", len(ring)))
+}
+
+// Add inserts a backend and rebuilds the balancer's hash ring
+func (balancer *ConsistentHash) Add(ctx context.Context, service *api.ServiceRegistration) Strategy {
+	if balancer.Header == "" {
+		balancer.Header, _ = HashTag(service.Tags)
+	}
+
+	upstreams, added := addUpstream(ctx, balancer.Upstreams, service, balancer.Rehash)
+	if !added {
+		return balancer
+	}
+
+	balancer.Upstreams = upstreams
+	balancer.Rehash(ctx)
+
+	return balancer
+}
+
+// Del removes a backend and rebuilds the balancer's hash ring
+func (balancer *ConsistentHash) Del(ctx context.Context, service *api.ServiceRegistration) Strategy {
+	upstreams, removed := delUpstream(ctx, balancer.Upstreams, service)
+	if !removed {
+		return balancer
+	}
+
+	if len(upstreams) == 0 {
+		return emptyStrategy{}
+	}
+
+	balancer.Upstreams = upstreams
+	balancer.Rehash(ctx)
+
+	return balancer
+}
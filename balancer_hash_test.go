@@ -0,0 +1,81 @@
+package nimby
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func hashKeyRequest(key string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	r.Header.Set(DefaultHashHeader, key)
+
+	return r
+}
+
+// TestConsistentHashStability asserts that a key's upstream assignment is
+// stable, and that adding an upstream remaps only a minority of keys rather
+// than reshuffling the whole ring.
+func TestConsistentHashStability(t *testing.T) {
+	ctx := context.Background()
+
+	a := &WeightedUpstream{ID: "a", Weight: 1}
+	b := &WeightedUpstream{ID: "b", Weight: 1}
+
+	a.health.active.Store(true)
+	b.health.active.Store(true)
+
+	balancer := &ConsistentHash{Upstreams: map[string]*WeightedUpstream{a.ID: a, b.ID: b}}
+	balancer.Rehash(ctx)
+
+	const keyCount = 200
+	keys := make([]string, keyCount)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("session-%d", i)
+	}
+
+	before := make(map[string]string, len(keys))
+	for _, key := range keys {
+		upstream, err := balancer.Next(hashKeyRequest(key))
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+
+		before[key] = upstream.ID
+	}
+
+	for _, key := range keys {
+		upstream, err := balancer.Next(hashKeyRequest(key))
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+
+		if upstream.ID != before[key] {
+			t.Fatalf("expected repeated lookups of %q to hit the same upstream, got %q then %q", key, before[key], upstream.ID)
+		}
+	}
+
+	c := &WeightedUpstream{ID: "c", Weight: 1}
+	c.health.active.Store(true)
+
+	balancer.Upstreams[c.ID] = c
+	balancer.Rehash(ctx)
+
+	moved := 0
+	for _, key := range keys {
+		upstream, err := balancer.Next(hashKeyRequest(key))
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+
+		if upstream.ID != before[key] {
+			moved++
+		}
+	}
+
+	if moved > len(keys)/2 {
+		t.Fatalf("expected adding an upstream to remap a minority of keys, moved %d/%d", moved, len(keys))
+	}
+}
@@ -0,0 +1,98 @@
+package nimby
+
+import (
+	"context"
+	"net/http"
+	"sort"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/jmanero/nimby/logging"
+	"go.uber.org/zap"
+)
+
+// LeastConn implements a least-connections Strategy, routing each request to
+// the upstream with the fewest in-flight requests
+type LeastConn struct {
+	Upstreams map[string]*WeightedUpstream
+
+	order []*WeightedUpstream
+
+	notEmptyStrategy
+}
+
+// Next selects the upstream with the fewest in-flight requests
+func (balancer *LeastConn) Next(*http.Request) (*WeightedUpstream, error) {
+	if len(balancer.order) == 0 {
+		return nil, ErrNoUpstreams
+	}
+
+	best := balancer.order[0]
+	for _, candidate := range balancer.order[1:] {
+		if candidate.InFlight() < best.InFlight() {
+			best = candidate
+		}
+	}
+
+	return best, nil
+}
+
+// Snapshot returns every upstream currently known to the balancer,
+// regardless of health, for the admin API
+func (balancer *LeastConn) Snapshot() []*WeightedUpstream {
+	upstreams := make([]*WeightedUpstream, 0, len(balancer.Upstreams))
+	for _, backend := range balancer.Upstreams {
+		upstreams = append(upstreams, backend)
+	}
+
+	return upstreams
+}
+
+// Rehash rebuilds the balancer's candidate order, excluding any upstream
+// that is currently unhealthy
+func (balancer *LeastConn) Rehash(ctx context.Context) {
+	ids := make([]string, 0, len(balancer.Upstreams))
+	for id := range balancer.Upstreams {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	order := make([]*WeightedUpstream, 0, len(ids))
+	for _, id := range ids {
+		if backend := balancer.Upstreams[id]; backend.Healthy() {
+			order = append(order, backend)
+		}
+	}
+
+	balancer.order = order
+	logging.Info(ctx, "balancer.rehash", zap.Int("count", len(balancer.Upstreams)), zap.Int("healthy", len(order)))
+}
+
+// Add inserts a backend and rebuilds the balancer's candidate order
+func (balancer *LeastConn) Add(ctx context.Context, service *api.ServiceRegistration) Strategy {
+	upstreams, added := addUpstream(ctx, balancer.Upstreams, service, balancer.Rehash)
+	if !added {
+		return balancer
+	}
+
+	balancer.Upstreams = upstreams
+	balancer.Rehash(ctx)
+
+	return balancer
+}
+
+// Del removes a backend and rebuilds the balancer's candidate order
+func (balancer *LeastConn) Del(ctx context.Context, service *api.ServiceRegistration) Strategy {
+	upstreams, removed := delUpstream(ctx, balancer.Upstreams, service)
+	if !removed {
+		return balancer
+	}
+
+	if len(upstreams) == 0 {
+		return emptyStrategy{}
+	}
+
+	balancer.Upstreams = upstreams
+	balancer.Rehash(ctx)
+
+	return balancer
+}
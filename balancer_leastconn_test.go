@@ -0,0 +1,29 @@
+package nimby
+
+import (
+	"context"
+	"testing"
+)
+
+// TestLeastConnPicksLowestInFlight asserts that LeastConn.Next always routes
+// to the healthy upstream with the fewest in-flight requests.
+func TestLeastConnPicksLowestInFlight(t *testing.T) {
+	busy := &WeightedUpstream{ID: "busy"}
+	idle := &WeightedUpstream{ID: "idle"}
+
+	busy.health.active.Store(true)
+	idle.health.active.Store(true)
+	busy.inflight.Store(5)
+
+	balancer := &LeastConn{Upstreams: map[string]*WeightedUpstream{busy.ID: busy, idle.ID: idle}}
+	balancer.Rehash(context.Background())
+
+	upstream, err := balancer.Next(nil)
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+
+	if upstream.ID != idle.ID {
+		t.Fatalf("expected least-conn to pick %q over %q, got %q", idle.ID, busy.ID, upstream.ID)
+	}
+}
@@ -0,0 +1,117 @@
+package nimby
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/jmanero/nimby/logging"
+	"go.uber.org/zap"
+)
+
+// P2C implements the "Power of Two Choices" Strategy: it samples two
+// upstreams uniformly at random and routes the request to whichever has
+// fewer in-flight requests, giving low-variance load distribution without
+// the coordination cost of tracking every upstream's load centrally
+type P2C struct {
+	Upstreams map[string]*WeightedUpstream
+
+	order  []*WeightedUpstream
+	random io.Reader
+
+	notEmptyStrategy
+}
+
+// Next samples two upstreams and returns the less-loaded of the pair
+func (balancer *P2C) Next(*http.Request) (*WeightedUpstream, error) {
+	n := uint64(len(balancer.order))
+
+	switch n {
+	case 0:
+		return nil, ErrNoUpstreams
+	case 1:
+		return balancer.order[0], nil
+	}
+
+	i, err := randomUint64(balancer.random)
+	if err != nil {
+		return nil, err
+	}
+
+	j, err := randomUint64(balancer.random)
+	if err != nil {
+		return nil, err
+	}
+
+	a := balancer.order[i%n]
+	b := balancer.order[j%n]
+
+	if b.InFlight() < a.InFlight() {
+		return b, nil
+	}
+
+	return a, nil
+}
+
+// Snapshot returns every upstream currently known to the balancer,
+// regardless of health, for the admin API
+func (balancer *P2C) Snapshot() []*WeightedUpstream {
+	upstreams := make([]*WeightedUpstream, 0, len(balancer.Upstreams))
+	for _, backend := range balancer.Upstreams {
+		upstreams = append(upstreams, backend)
+	}
+
+	return upstreams
+}
+
+// Rehash rebuilds the balancer's sampling order, excluding any upstream that
+// is currently unhealthy
+func (balancer *P2C) Rehash(ctx context.Context) {
+	ids := make([]string, 0, len(balancer.Upstreams))
+	for id := range balancer.Upstreams {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	order := make([]*WeightedUpstream, 0, len(ids))
+	for _, id := range ids {
+		if backend := balancer.Upstreams[id]; backend.Healthy() {
+			order = append(order, backend)
+		}
+	}
+
+	balancer.order = order
+	logging.Info(ctx, "balancer.rehash", zap.Int("count", len(balancer.Upstreams)), zap.Int("healthy", len(order)))
+}
+
+// Add inserts a backend and rebuilds the balancer's sampling order
+func (balancer *P2C) Add(ctx context.Context, service *api.ServiceRegistration) Strategy {
+	upstreams, added := addUpstream(ctx, balancer.Upstreams, service, balancer.Rehash)
+	if !added {
+		return balancer
+	}
+
+	balancer.Upstreams = upstreams
+	balancer.Rehash(ctx)
+
+	return balancer
+}
+
+// Del removes a backend and rebuilds the balancer's sampling order
+func (balancer *P2C) Del(ctx context.Context, service *api.ServiceRegistration) Strategy {
+	upstreams, removed := delUpstream(ctx, balancer.Upstreams, service)
+	if !removed {
+		return balancer
+	}
+
+	if len(upstreams) == 0 {
+		return emptyStrategy{}
+	}
+
+	balancer.Upstreams = upstreams
+	balancer.Rehash(ctx)
+
+	return balancer
+}
@@ -0,0 +1,37 @@
+package nimby
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+)
+
+// TestP2CDistribution asserts that, between equally idle upstreams, P2C's
+// sampling spreads selections roughly evenly rather than favoring one.
+func TestP2CDistribution(t *testing.T) {
+	a := &WeightedUpstream{ID: "a"}
+	b := &WeightedUpstream{ID: "b"}
+
+	a.health.active.Store(true)
+	b.health.active.Store(true)
+
+	balancer := &P2C{Upstreams: map[string]*WeightedUpstream{a.ID: a, b.ID: b}, random: rand.Reader}
+	balancer.Rehash(context.Background())
+
+	const trials = 20000
+	counts := make(map[string]int, 2)
+
+	for i := 0; i < trials; i++ {
+		upstream, err := balancer.Next(nil)
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+
+		counts[upstream.ID]++
+	}
+
+	ratio := float64(counts[a.ID]) / float64(counts[b.ID])
+	if ratio < 0.9 || ratio > 1.1 {
+		t.Fatalf("expected roughly even selection between idle upstreams, got a=%d b=%d", counts[a.ID], counts[b.ID])
+	}
+}
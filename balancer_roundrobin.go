@@ -0,0 +1,94 @@
+package nimby
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync/atomic"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/jmanero/nimby/logging"
+	"go.uber.org/zap"
+)
+
+// RoundRobin implements a round-robin Strategy, cycling through upstreams in
+// a stable order regardless of weight
+type RoundRobin struct {
+	Upstreams map[string]*WeightedUpstream
+
+	order []*WeightedUpstream
+	next  atomic.Uint64
+
+	notEmptyStrategy
+}
+
+// Next selects the next upstream in rotation
+func (balancer *RoundRobin) Next(*http.Request) (*WeightedUpstream, error) {
+	if len(balancer.order) == 0 {
+		return nil, ErrNoUpstreams
+	}
+
+	idx := balancer.next.Add(1) - 1
+	return balancer.order[idx%uint64(len(balancer.order))], nil
+}
+
+// Snapshot returns every upstream currently known to the balancer,
+// regardless of health, for the admin API
+func (balancer *RoundRobin) Snapshot() []*WeightedUpstream {
+	upstreams := make([]*WeightedUpstream, 0, len(balancer.Upstreams))
+	for _, backend := range balancer.Upstreams {
+		upstreams = append(upstreams, backend)
+	}
+
+	return upstreams
+}
+
+// Rehash rebuilds the balancer's rotation order, excluding any upstream that
+// is currently unhealthy
+func (balancer *RoundRobin) Rehash(ctx context.Context) {
+	ids := make([]string, 0, len(balancer.Upstreams))
+	for id := range balancer.Upstreams {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	order := make([]*WeightedUpstream, 0, len(ids))
+	for _, id := range ids {
+		if backend := balancer.Upstreams[id]; backend.Healthy() {
+			order = append(order, backend)
+		}
+	}
+
+	balancer.order = order
+	logging.Info(ctx, "balancer.rehash", zap.Int("count", len(balancer.Upstreams)), zap.Int("healthy", len(order)))
+}
+
+// Add inserts a backend and rebuilds the balancer's rotation order
+func (balancer *RoundRobin) Add(ctx context.Context, service *api.ServiceRegistration) Strategy {
+	upstreams, added := addUpstream(ctx, balancer.Upstreams, service, balancer.Rehash)
+	if !added {
+		return balancer
+	}
+
+	balancer.Upstreams = upstreams
+	balancer.Rehash(ctx)
+
+	return balancer
+}
+
+// Del removes a backend and rebuilds the balancer's rotation order
+func (balancer *RoundRobin) Del(ctx context.Context, service *api.ServiceRegistration) Strategy {
+	upstreams, removed := delUpstream(ctx, balancer.Upstreams, service)
+	if !removed {
+		return balancer
+	}
+
+	if len(upstreams) == 0 {
+		return emptyStrategy{}
+	}
+
+	balancer.Upstreams = upstreams
+	balancer.Rehash(ctx)
+
+	return balancer
+}
@@ -0,0 +1,39 @@
+package nimby
+
+import (
+	"context"
+	"testing"
+)
+
+// TestRoundRobinRotation asserts that RoundRobin.Next cycles through every
+// healthy upstream exactly once per lap, regardless of insertion order.
+func TestRoundRobinRotation(t *testing.T) {
+	a := &WeightedUpstream{ID: "a"}
+	b := &WeightedUpstream{ID: "b"}
+	c := &WeightedUpstream{ID: "c"}
+
+	for _, backend := range []*WeightedUpstream{a, b, c} {
+		backend.health.active.Store(true)
+	}
+
+	balancer := &RoundRobin{Upstreams: map[string]*WeightedUpstream{a.ID: a, b.ID: b, c.ID: c}}
+	balancer.Rehash(context.Background())
+
+	const laps = 3
+	counts := make(map[string]int, 3)
+
+	for i := 0; i < laps*len(balancer.order); i++ {
+		upstream, err := balancer.Next(nil)
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+
+		counts[upstream.ID]++
+	}
+
+	for _, backend := range []*WeightedUpstream{a, b, c} {
+		if counts[backend.ID] != laps {
+			t.Fatalf("expected %d selections for %q over %d laps, got %d", laps, backend.ID, laps, counts[backend.ID])
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package nimby
+
+import (
+	"context"
+	"crypto/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/hashicorp/nomad/api"
+)
+
+// newTestUpstream builds a WeightedUpstream backed by an httptest.Server,
+// stopping its active health checker when the test completes.
+func newTestUpstream(t *testing.T, id string, weight uint64, handler http.HandlerFunc) *WeightedUpstream {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	host, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("split listener address: %v", err)
+	}
+
+	portNum, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatalf("parse listener port: %v", err)
+	}
+
+	service := &api.ServiceRegistration{ID: id, Address: host, Port: portNum}
+
+	backend := NewWeightedUpstream(context.Background(), service, weight, func(context.Context) {})
+	t.Cleanup(backend.StopHealthCheck)
+
+	return backend
+}
+
+// TestWeightedRandomDistribution asserts that WeightedRandom.Next selects
+// upstreams in proportion to their registered weight.
+func TestWeightedRandomDistribution(t *testing.T) {
+	light := &WeightedUpstream{ID: "light", Weight: 1}
+	heavy := &WeightedUpstream{ID: "heavy", Weight: 3}
+
+	light.health.active.Store(true)
+	heavy.health.active.Store(true)
+
+	balancer := &WeightedRandom{
+		Upstreams: map[string]*WeightedUpstream{light.ID: light, heavy.ID: heavy},
+		Total:     light.Weight + heavy.Weight,
+		random:    rand.Reader,
+	}
+	balancer.Rehash(context.Background())
+
+	const trials = 20000
+	counts := make(map[string]int, 2)
+
+	for i := 0; i < trials; i++ {
+		upstream, err := balancer.Next(nil)
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+
+		counts[upstream.ID]++
+	}
+
+	ratio := float64(counts[heavy.ID]) / float64(counts[light.ID])
+	if ratio < 2.5 || ratio > 3.5 {
+		t.Fatalf("expected ~3x selection ratio for weight 3 vs 1, got %.2f (light=%d heavy=%d)", ratio, counts[light.ID], counts[heavy.ID])
+	}
+}
+
+// TestWeightedUpstreamInFlight asserts that ServeHTTP tracks a request for
+// the duration it is proxied, and clears it once the request completes.
+func TestWeightedUpstreamInFlight(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	backend := newTestUpstream(t, "inflight", 1, func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+
+		w.WriteHeader(http.StatusOK)
+	})
+
+	if n := backend.InFlight(); n != 0 {
+		t.Fatalf("expected 0 in-flight before any request, got %d", n)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		backend.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+	}()
+
+	<-started
+	if n := backend.InFlight(); n != 1 {
+		t.Fatalf("expected 1 in-flight mid-request, got %d", n)
+	}
+
+	close(release)
+	<-done
+
+	if n := backend.InFlight(); n != 0 {
+		t.Fatalf("expected 0 in-flight after request completes, got %d", n)
+	}
+}
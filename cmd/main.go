@@ -12,6 +12,9 @@ import (
 
 	"github.com/jmanero/nimby"
 	"github.com/jmanero/nimby/logging"
+	"github.com/jmanero/nimby/metrics"
+	"github.com/jmanero/nimby/tls"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
 )
@@ -41,7 +44,7 @@ func Main() error {
 	}
 
 	server := http.Server{
-		Handler: controller,
+		Handler: metrics.Wrap(controller),
 		Addr:    nimby.EnvString("NIMBY_ADDR", "0.0.0.0:9876"),
 		ConnContext: func(ctx context.Context, conn net.Conn) context.Context {
 			// Inject an annotated logger into request contexts
@@ -54,20 +57,79 @@ func Main() error {
 
 	server.ErrorLog, _ = zap.NewStdLogAt(logger, zap.ErrorLevel)
 
+	registry := metrics.NewRegistry(controller.Snapshot)
+
+	admin := http.Server{
+		Handler: nimby.NewAdminHandler(controller, promhttp.HandlerFor(registry, promhttp.HandlerOpts{})),
+		Addr:    nimby.EnvString("NIMBY_ADMIN_ADDR", "0.0.0.0:9877"),
+	}
+
+	admin.ErrorLog, _ = zap.NewStdLogAt(logger, zap.ErrorLevel)
+
+	manager, err := tls.New(tls.Options{
+		Mode:     tls.Mode(nimby.EnvString("NIMBY_TLS", "off")),
+		CacheDir: nimby.EnvString("NIMBY_TLS_CACHE", "/var/cache/nimby"),
+		Email:    nimby.EnvString("NIMBY_TLS_EMAIL", ""),
+		CertFile: nimby.EnvString("NIMBY_TLS_CERT", ""),
+		KeyFile:  nimby.EnvString("NIMBY_TLS_KEY", ""),
+	}, controller)
+
+	if err != nil {
+		logger.Error("tls.error", zap.Error(err))
+		return err
+	}
+
 	group, ctx := errgroup.WithContext(ctx)
 
 	logger.Info("http.listen", zap.String("http.addr", server.Addr))
 	group.Go(func() error { return nimby.NotError(server.ListenAndServe(), http.ErrServerClosed) })
 
+	logger.Info("admin.listen", zap.String("admin.addr", admin.Addr))
+	group.Go(func() error { return nimby.NotError(admin.ListenAndServe(), http.ErrServerClosed) })
+
 	group.Go(func() error { return controller.TokenReloader(ctx) })
 	group.Go(func() error { return controller.Updater(ctx) })
 
+	var https, acme http.Server
+
+	if manager.Mode != tls.ModeOff {
+		https = http.Server{
+			Handler:   metrics.Wrap(controller),
+			Addr:      nimby.EnvString("NIMBY_TLS_ADDR", "0.0.0.0:443"),
+			TLSConfig: manager.TLSConfig(),
+		}
+		https.ErrorLog, _ = zap.NewStdLogAt(logger, zap.ErrorLevel)
+
+		acme = http.Server{
+			Handler: manager.HTTPHandler(),
+			Addr:    nimby.EnvString("NIMBY_TLS_HTTP_ADDR", "0.0.0.0:80"),
+		}
+		acme.ErrorLog, _ = zap.NewStdLogAt(logger, zap.ErrorLevel)
+
+		logger.Info("https.listen", zap.String("https.addr", https.Addr))
+		group.Go(func() error { return nimby.NotError(https.ListenAndServeTLS("", ""), http.ErrServerClosed) })
+
+		logger.Info("acme.listen", zap.String("http.addr", acme.Addr))
+		group.Go(func() error { return nimby.NotError(acme.ListenAndServe(), http.ErrServerClosed) })
+	}
+
 	<-ctx.Done()
 	logger.Info("service.stopping")
 
 	err = nimby.Shutdown(&server, time.Minute)
 	logger.Error("http.shutdown", zap.Error(err))
 
+	err = nimby.Shutdown(&admin, time.Minute)
+	logger.Error("admin.shutdown", zap.Error(err))
+
+	if manager.Mode != tls.ModeOff {
+		err = nimby.Shutdown(&https, time.Minute)
+		logger.Error("https.shutdown", zap.Error(err))
+
+		err = nimby.Shutdown(&acme, time.Minute)
+		logger.Error("acme.shutdown", zap.Error(err))
+	}
+
 	logger.Info("service.stopped")
 	return group.Wait()
 }
@@ -11,6 +11,7 @@ import (
 
 	"github.com/hashicorp/nomad/api"
 	"github.com/jmanero/nimby/logging"
+	"github.com/jmanero/nimby/metrics"
 	"go.uber.org/zap"
 )
 
@@ -72,8 +73,64 @@ func (controller *Controller) Get(domain string) (balancer Handler, has bool) {
 	return
 }
 
-// Add inserts a new service instance to the controller, creating a Balancer
-// instance for the service if one does not already exist
+// Domains returns a snapshot of every domain currently registered with the
+// controller, keyed by host. It relies on sync.Map's own synchronization and
+// is safe to call concurrently with Add/Del.
+func (controller *Controller) Domains() map[string]Handler {
+	domains := make(map[string]Handler)
+
+	controller.Range(func(key, value any) bool {
+		if balancer, has := value.(Handler); has {
+			domains[key.(string)] = balancer
+		}
+
+		return true
+	})
+
+	return domains
+}
+
+// Drain marks a domain as draining: it stops accepting new upstreams and
+// serves 503 for proxied requests, but keeps deregistering upstreams
+// normally so the domain is removed once Nomad drains the last of them.
+func (controller *Controller) Drain(domain string) bool {
+	controller.mu.Lock()
+	defer controller.mu.Unlock()
+
+	balancer, has := controller.Get(domain)
+	if !has {
+		return false
+	}
+
+	controller.Store(domain, drainingHandler{balancer})
+	return true
+}
+
+// drainingHandler wraps a Handler to refuse new upstreams and serve 503,
+// while still delegating Del so the wrapped domain can drain naturally
+type drainingHandler struct {
+	Handler
+}
+
+func (d drainingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "Domain Draining", http.StatusServiceUnavailable)
+}
+
+func (d drainingHandler) Add(context.Context, *api.ServiceRegistration) Handler {
+	return d
+}
+
+func (d drainingHandler) Del(ctx context.Context, service *api.ServiceRegistration) Handler {
+	next := d.Handler.Del(ctx, service)
+	if next.Empty() {
+		return next
+	}
+
+	return drainingHandler{next}
+}
+
+// Add inserts a new service instance to the controller, creating a Router
+// instance for the domain if one does not already exist
 func (controller *Controller) Add(ctx context.Context, service *api.ServiceRegistration) Handler {
 
 	domain, has := DomainTag(service.Tags)
@@ -89,17 +146,17 @@ func (controller *Controller) Add(ctx context.Context, service *api.ServiceRegis
 	controller.mu.Lock()
 	defer controller.mu.Unlock()
 
-	balancer, has := controller.Get(domain)
+	handler, has := controller.Get(domain)
 	if !has {
 		logger.Info("service.add")
-		balancer = NewBalancer(service.Tags)
+		handler = newRouter()
 	}
 
-	controller.Store(domain, balancer.Add(ctx, service))
+	controller.Store(domain, handler.Add(ctx, service))
 	return controller
 }
 
-// Del removes a service instance from the controller, removing an empty Balancer
+// Del removes a service instance from the controller, removing an empty Handler
 func (controller *Controller) Del(ctx context.Context, service *api.ServiceRegistration) Handler {
 
 	domain, has := DomainTag(service.Tags)
@@ -123,7 +180,7 @@ func (controller *Controller) Del(ctx context.Context, service *api.ServiceRegis
 	balancer = balancer.Del(ctx, service)
 
 	if balancer.Empty() {
-		// Remove the domain for an empty Balancer from the controller
+		// Remove the domain for an empty Handler from the controller
 		logger.Info("service.remove")
 		controller.Delete(domain)
 	} else {
@@ -245,6 +302,8 @@ func (controller *Controller) Updater(ctx context.Context) (err error) {
 					continue
 				}
 
+				metrics.NomadEventsTotal.WithLabelValues(ev.Type).Inc()
+
 				switch ev.Type {
 				case "ServiceRegistration":
 					controller.Add(ctx, sv)
@@ -0,0 +1,215 @@
+package nimby
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmanero/nimby/logging"
+	"go.uber.org/zap"
+)
+
+// DefaultHealthPath is the active-check path used when a service carries no
+// `nimby-health-path:` tag
+const DefaultHealthPath = "/"
+
+// Active and passive health-check tuning, configurable via environment so
+// operators can adapt to a backend's startup/failure characteristics without
+// a rebuild.
+var (
+	HealthCheckInterval  = EnvDuration("NIMBY_HEALTHCHECK_INTERVAL", 10*time.Second)
+	HealthCheckTimeout   = EnvDuration("NIMBY_HEALTHCHECK_TIMEOUT", 2*time.Second)
+	HealthCheckThreshold = EnvInt("NIMBY_HEALTHCHECK_THRESHOLD", 3)
+	HealthCheckStatusMin = EnvInt("NIMBY_HEALTHCHECK_STATUS_MIN", 200)
+	HealthCheckStatusMax = EnvInt("NIMBY_HEALTHCHECK_STATUS_MAX", 399)
+
+	PassiveWindow           = EnvInt("NIMBY_HEALTHCHECK_PASSIVE_WINDOW", 10)
+	PassiveFailureThreshold = EnvInt("NIMBY_HEALTHCHECK_PASSIVE_THRESHOLD", 5)
+	PassiveCooldown         = EnvDuration("NIMBY_HEALTHCHECK_PASSIVE_COOLDOWN", 30*time.Second)
+)
+
+// health tracks a WeightedUpstream's active- and passive-check state. An
+// upstream is eligible for traffic only while both the active checker
+// considers it up and it isn't passively ejected.
+type health struct {
+	backend *WeightedUpstream
+	path    string
+	rehash  func(context.Context)
+
+	active  atomic.Bool  // set by the active checker; the zero value counts as down, so init stores true
+	strikes atomic.Int32 // consecutive active-check failures
+
+	mu           sync.Mutex
+	window       []bool // ring buffer of recent passive outcomes, true == success
+	next         int
+	failures     int
+	ejectedUntil time.Time
+
+	cancel context.CancelFunc
+}
+
+// init prepares a freshly-constructed WeightedUpstream's health state and
+// starts its active-check goroutine. ctx should outlive the upstream; it is
+// stopped explicitly via stop when the upstream is removed from its
+// balancer. rehash is called whenever a health-state transition should
+// remove or restore the upstream from its Strategy's rotation.
+func (h *health) init(ctx context.Context, backend *WeightedUpstream, path string, rehash func(context.Context)) {
+	h.backend = backend
+	h.path = path
+	h.rehash = rehash
+	h.active.Store(true)
+
+	ctx, h.cancel = context.WithCancel(ctx)
+	go h.run(ctx)
+}
+
+// notifyChange triggers the upstream's owning Strategy to Rehash, so an
+// active- or passive-check transition takes effect immediately instead of
+// waiting for an unrelated Add/Del
+func (h *health) notifyChange(ctx context.Context) {
+	if h.rehash != nil {
+		h.rehash(ctx)
+	}
+}
+
+// stop cancels the upstream's active-check goroutine, e.g. when it is
+// removed from its balancer
+func (h *health) stop() {
+	if h.cancel != nil {
+		h.cancel()
+	}
+}
+
+// healthy reports whether the upstream should currently receive traffic
+func (h *health) healthy() bool {
+	if !h.active.Load() {
+		return false
+	}
+
+	// A passive ejection lasts until reinstate clears it after a successful
+	// post-cooldown probe, not merely until the cooldown elapses
+	h.mu.Lock()
+	ejected := !h.ejectedUntil.IsZero()
+	h.mu.Unlock()
+
+	return !ejected
+}
+
+// recordOutcome folds a proxied request's result into the passive sliding
+// window, ejecting the upstream once failures reach PassiveFailureThreshold
+// and notifying its Strategy so the ejection takes effect immediately
+func (h *health) recordOutcome(ctx context.Context, success bool) {
+	if h.updateWindow(success) {
+		h.notifyChange(ctx)
+	}
+}
+
+// updateWindow applies a single passive outcome to the sliding window and
+// reports whether it newly ejected the upstream
+func (h *health) updateWindow(success bool) (ejected bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.window) < PassiveWindow {
+		h.window = append(h.window, false)
+	} else if !h.window[h.next] {
+		h.failures--
+	}
+
+	h.window[h.next] = success
+	if !success {
+		h.failures++
+	}
+	h.next = (h.next + 1) % PassiveWindow
+
+	if h.failures >= PassiveFailureThreshold && h.ejectedUntil.IsZero() {
+		h.ejectedUntil = time.Now().Add(PassiveCooldown)
+		ejected = true
+	}
+
+	return
+}
+
+// reinstate clears an upstream's passive ejection and resets its window,
+// called once a post-cooldown probe succeeds
+func (h *health) reinstate() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.ejectedUntil = time.Time{}
+	h.window, h.next, h.failures = h.window[:0], 0, 0
+}
+
+// cooledDown reports whether a passively-ejected upstream's cooldown has
+// elapsed and it is due for a reinstatement probe
+func (h *health) cooledDown() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return !h.ejectedUntil.IsZero() && !time.Now().Before(h.ejectedUntil)
+}
+
+// run is the upstream's active-check loop: it probes the health path every
+// HealthCheckInterval, and additionally attempts to reinstate a passively
+// ejected upstream once its cooldown has elapsed
+func (h *health) run(ctx context.Context) {
+	ticker := time.NewTicker(HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.probe(ctx)
+		}
+	}
+}
+
+// probe issues a single active-check GET against the upstream's health path,
+// updating its consecutive-failure counter and, for a passively-ejected
+// upstream whose cooldown has elapsed, reinstating it on success
+func (h *health) probe(ctx context.Context) {
+	ctx, done := context.WithTimeout(ctx, HealthCheckTimeout)
+	defer done()
+
+	target := h.backend.Endpoint
+	target.Path = h.path
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	ok := err == nil
+
+	if ok {
+		res, rtErr := Transport.RoundTrip(req)
+		ok = rtErr == nil && res.StatusCode >= HealthCheckStatusMin && res.StatusCode <= HealthCheckStatusMax
+
+		if res != nil {
+			res.Body.Close()
+		}
+	}
+
+	if !ok {
+		strikes := h.strikes.Add(1)
+		if strikes >= int32(HealthCheckThreshold) && h.active.CompareAndSwap(true, false) {
+			h.notifyChange(ctx)
+		}
+
+		return
+	}
+
+	h.strikes.Store(0)
+	reinstated := false
+
+	if h.cooledDown() {
+		h.reinstate()
+		reinstated = true
+
+		logging.Info(ctx, "upstream.reinstated", zap.String("id", h.backend.ID), zap.Stringer("endpoint", &h.backend.Endpoint))
+	}
+
+	if becameActive := h.active.CompareAndSwap(false, true); becameActive || reinstated {
+		h.notifyChange(ctx)
+	}
+}
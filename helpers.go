@@ -2,6 +2,7 @@ package nimby
 
 import (
 	"context"
+	"errors"
 	"net"
 	"net/http"
 	"net/url"
@@ -15,10 +16,16 @@ import (
 
 // Tag Prefixes
 const (
-	DomainTagPrefix = "nimby-domain:"
-	WeightTagPrefix = "nimby-weight:"
-	ProtoTagPrefix  = "nimby-proto:"
-	PathTagPrefix   = "nimby-path:"
+	DomainTagPrefix     = "nimby-domain:"
+	WeightTagPrefix     = "nimby-weight:"
+	ProtoTagPrefix      = "nimby-proto:"
+	PathTagPrefix       = "nimby-path:"
+	HostTagPrefix       = "nimby-host:"
+	StrategyTagPrefix   = "nimby-strategy:"
+	HashTagPrefix       = "nimby-hash:"
+	HealthPathTagPrefix = "nimby-health-path:"
+	MatchTagPrefix      = "nimby-match:"
+	MWTagPrefix         = "nimby-mw:"
 )
 
 // DomainTag attempts to extract a DOMAIN value from a tag string `nimby-domain:STRING`
@@ -73,6 +80,80 @@ func PathTag(tags []string) (value string, has bool) {
 	return
 }
 
+// HostTag attempts to extract a Host header override from a tag string `nimby-host:STRING`
+func HostTag(tags []string) (value string, has bool) {
+	for _, tag := range tags {
+		value, has = strings.CutPrefix(tag, HostTagPrefix)
+		if has {
+			return
+		}
+	}
+
+	return
+}
+
+// StrategyTag attempts to extract a balancer strategy name from a tag string `nimby-strategy:STRING`
+func StrategyTag(tags []string) (value string, has bool) {
+	for _, tag := range tags {
+		value, has = strings.CutPrefix(tag, StrategyTagPrefix)
+		if has {
+			return
+		}
+	}
+
+	return
+}
+
+// HashTag attempts to extract a request header name to key a consistent-hash
+// strategy on from a tag string `nimby-hash:STRING`
+func HashTag(tags []string) (value string, has bool) {
+	for _, tag := range tags {
+		value, has = strings.CutPrefix(tag, HashTagPrefix)
+		if has {
+			return
+		}
+	}
+
+	return
+}
+
+// HealthPathTag attempts to extract an active health-check path from a tag string `nimby-health-path:STRING`
+func HealthPathTag(tags []string) (value string, has bool) {
+	for _, tag := range tags {
+		value, has = strings.CutPrefix(tag, HealthPathTagPrefix)
+		if has {
+			return
+		}
+	}
+
+	return
+}
+
+// MatchTag attempts to extract a routing-rule expression from a tag string
+// `nimby-match:EXPR`, e.g. `nimby-match:PathPrefix(/api) && Method(GET)`
+func MatchTag(tags []string) (value string, has bool) {
+	for _, tag := range tags {
+		value, has = strings.CutPrefix(tag, MatchTagPrefix)
+		if has {
+			return
+		}
+	}
+
+	return
+}
+
+// MWTags returns every `nimby-mw:NAME[=ARG]` tag's value, in tag order, for
+// composing a domain's middleware chain
+func MWTags(tags []string) (values []string) {
+	for _, tag := range tags {
+		if value, has := strings.CutPrefix(tag, MWTagPrefix); has {
+			values = append(values, value)
+		}
+	}
+
+	return
+}
+
 // UpstreamService builds a URL from a ServiceRegistration struct
 func UpstreamService(service *api.ServiceRegistration) (uri url.URL) {
 	uri.Scheme = "http"
@@ -108,6 +189,28 @@ func EnvStrings(name, sep string, values []string) []string {
 	return values
 }
 
+// EnvDuration is a helper to lookup an environment variable duration-value or return a default
+func EnvDuration(name string, value time.Duration) time.Duration {
+	if env, has := os.LookupEnv(name); has {
+		if parsed, err := time.ParseDuration(env); err == nil {
+			return parsed
+		}
+	}
+
+	return value
+}
+
+// EnvInt is a helper to lookup an environment variable integer-value or return a default
+func EnvInt(name string, value int) int {
+	if env, has := os.LookupEnv(name); has {
+		if parsed, err := strconv.Atoi(env); err == nil {
+			return parsed
+		}
+	}
+
+	return value
+}
+
 // Shutdown is a helper to shutdown an HTTP server with a timeout
 func Shutdown(server *http.Server, timeout time.Duration) error {
 	ctx, done := context.WithTimeout(context.Background(), timeout)
@@ -115,3 +218,14 @@ func Shutdown(server *http.Server, timeout time.Duration) error {
 	defer done()
 	return server.Shutdown(ctx)
 }
+
+// NotError is a helper for errgroup.Group.Go that treats an expected
+// error, e.g. http.ErrServerClosed from a server stopped by Shutdown, as a
+// clean return instead of one that tears down the rest of the group
+func NotError(err, expected error) error {
+	if errors.Is(err, expected) {
+		return nil
+	}
+
+	return err
+}
@@ -79,3 +79,10 @@ func Error(ctx context.Context, msg string, fields ...zapcore.Field) {
 	_, logger := Logger(ctx)
 	logger.Error(msg, fields...)
 }
+
+// Check is a helper that gets the Context's logger and calls its Check method,
+// letting callers in hot paths skip building fields when the level is disabled
+func Check(ctx context.Context, lvl zapcore.Level, msg string) *zapcore.CheckedEntry {
+	_, logger := Logger(ctx)
+	return logger.Check(lvl, msg)
+}
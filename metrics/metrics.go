@@ -0,0 +1,135 @@
+// Package metrics exposes Nimby's Prometheus instrumentation: request and
+// upstream counters/histograms, and a Collector that reports balancer/
+// upstream gauges from a caller-supplied snapshot function at scrape time.
+// This package intentionally never imports github.com/jmanero/nimby, so the
+// nimby package is free to import metrics without an import cycle.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// HTTPRequestsTotal counts proxied requests by domain, method, and response code
+var HTTPRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "nimby_http_requests_total",
+	Help: "Total number of requests proxied to a domain",
+}, []string{"domain", "method", "code"})
+
+// HTTPRequestDuration observes end-to-end latency of proxied requests, by domain
+var HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "nimby_http_request_duration_seconds",
+	Help:    "End-to-end latency of proxied requests, by domain",
+	Buckets: prometheus.DefBuckets,
+}, []string{"domain"})
+
+// UpstreamRequestsTotal counts requests proxied to a specific upstream, by response code
+var UpstreamRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "nimby_upstream_requests_total",
+	Help: "Total number of requests proxied to a specific upstream",
+}, []string{"domain", "upstream", "code"})
+
+// NomadEventsTotal counts Nomad service events consumed from the event
+// stream by Controller.Updater, by event type
+var NomadEventsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "nimby_nomad_events_total",
+	Help: "Total number of Nomad service events consumed from the event stream",
+}, []string{"type"})
+
+// DomainSnapshot describes a single domain's balancer for the gauge Collector
+type DomainSnapshot struct {
+	Host      string
+	Upstreams []UpstreamSnapshot
+}
+
+// UpstreamSnapshot describes a single upstream for the gauge Collector
+type UpstreamSnapshot struct {
+	ID       string
+	InFlight int64
+	Healthy  bool
+}
+
+var (
+	upstreamInFlightDesc  = prometheus.NewDesc("nimby_upstream_inflight", "Number of requests currently being proxied to an upstream", []string{"domain", "upstream"}, nil)
+	upstreamHealthyDesc   = prometheus.NewDesc("nimby_upstream_healthy", "Whether an upstream is currently eligible to receive traffic", []string{"domain", "upstream"}, nil)
+	balancerUpstreamsDesc = prometheus.NewDesc("nimby_balancer_upstreams", "Number of upstreams registered to a domain's balancer", []string{"domain"}, nil)
+)
+
+// collector reports live balancer and upstream gauges from snapshot at
+// scrape time, rather than updating gauges from the request hot path
+type collector struct {
+	snapshot func() []DomainSnapshot
+}
+
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- upstreamInFlightDesc
+	ch <- upstreamHealthyDesc
+	ch <- balancerUpstreamsDesc
+}
+
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	for _, domain := range c.snapshot() {
+		ch <- prometheus.MustNewConstMetric(balancerUpstreamsDesc, prometheus.GaugeValue, float64(len(domain.Upstreams)), domain.Host)
+
+		for _, upstream := range domain.Upstreams {
+			ch <- prometheus.MustNewConstMetric(upstreamInFlightDesc, prometheus.GaugeValue, float64(upstream.InFlight), domain.Host, upstream.ID)
+
+			healthy := 0.0
+			if upstream.Healthy {
+				healthy = 1
+			}
+			ch <- prometheus.MustNewConstMetric(upstreamHealthyDesc, prometheus.GaugeValue, healthy, domain.Host, upstream.ID)
+		}
+	}
+}
+
+// NewRegistry builds a prometheus.Registry carrying Nimby's counters,
+// histograms, and a gauge Collector fed by snapshot
+func NewRegistry(snapshot func() []DomainSnapshot) *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+
+	registry.MustRegister(
+		HTTPRequestsTotal,
+		HTTPRequestDuration,
+		UpstreamRequestsTotal,
+		NomadEventsTotal,
+		&collector{snapshot: snapshot},
+	)
+
+	return registry
+}
+
+// Wrap instruments an http.Handler with the domain-level request counter and
+// duration histogram. It expects r.Host to carry the request's ingress domain.
+func Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		start := time.Now()
+
+		next.ServeHTTP(recorder, r)
+
+		HTTPRequestsTotal.WithLabelValues(r.Host, r.Method, strconv.Itoa(recorder.code)).Inc()
+		HTTPRequestDuration.WithLabelValues(r.Host).Observe(time.Since(start).Seconds())
+	})
+}
+
+// statusRecorder captures the status code written by an inner http.Handler
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (recorder *statusRecorder) WriteHeader(code int) {
+	recorder.code = code
+	recorder.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap exposes the wrapped http.ResponseWriter to http.NewResponseController
+// and httputil.ReverseProxy's own type-assertions, so Hijack/Flush still work
+// for upgraded and streamed responses proxied through Wrap.
+func (recorder *statusRecorder) Unwrap() http.ResponseWriter {
+	return recorder.ResponseWriter
+}
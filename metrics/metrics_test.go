@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func noopHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+// BenchmarkWrap compares a bare handler against one instrumented with Wrap,
+// to confirm the counter/histogram bookkeeping doesn't meaningfully slow the
+// proxy's hot path.
+func BenchmarkWrap(b *testing.B) {
+	b.Run("bare", func(b *testing.B) {
+		handler := http.HandlerFunc(noopHandler)
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+		}
+	})
+
+	b.Run("wrapped", func(b *testing.B) {
+		handler := Wrap(http.HandlerFunc(noopHandler))
+		r := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+		}
+	})
+}
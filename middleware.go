@@ -0,0 +1,67 @@
+package nimby
+
+import (
+	"context"
+	"net/http"
+	"slices"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/jmanero/nimby/logging"
+	"github.com/jmanero/nimby/middleware"
+	"go.uber.org/zap"
+)
+
+// mwHandler wraps a single Router rule's Handler with its nimby-mw:
+// middleware chain, so distinct nimby-match: rules on the same domain each
+// keep their own chain instead of sharing one across the whole domain. Add
+// resolves the chain once per rebuild and reuses it across Add calls that
+// carry the same middleware tag set, so stateful middleware (like the rate
+// limiter's per-IP buckets) survives routine rolling deploys; changes to the
+// tag set rebuild the chain atomically on the next Add.
+type mwHandler struct {
+	Handler
+
+	tags        []string
+	middlewares []middleware.Middleware
+	chain       http.Handler
+}
+
+func (h mwHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.chain.ServeHTTP(w, r)
+}
+
+// Add delegates to the wrapped Handler, then resolves and rewraps the
+// middleware chain, reusing the existing middleware instances unless the
+// service's nimby-mw: tags have changed
+func (h mwHandler) Add(ctx context.Context, service *api.ServiceRegistration) Handler {
+	next := h.Handler.Add(ctx, service)
+	tags := MWTags(service.Tags)
+
+	middlewares := h.middlewares
+	if h.middlewares == nil || !slices.Equal(tags, h.tags) {
+		built, err := middleware.Resolve(tags)
+		if err != nil {
+			_, logger := logging.Logger(ctx)
+			logger.Warn("middleware.invalid", zap.Strings("tags", tags), zap.Error(err))
+		} else {
+			middlewares = built
+		}
+	}
+
+	return mwHandler{Handler: next, tags: tags, middlewares: middlewares, chain: middleware.Wrap(middlewares, next)}
+}
+
+// Del delegates to the wrapped Handler, reusing the current middleware
+// chain around whatever Handler remains
+func (h mwHandler) Del(ctx context.Context, service *api.ServiceRegistration) Handler {
+	next := h.Handler.Del(ctx, service)
+	if next.Empty() {
+		return next
+	}
+
+	return mwHandler{Handler: next, tags: h.tags, middlewares: h.middlewares, chain: middleware.Wrap(h.middlewares, next)}
+}
+
+func (h mwHandler) Empty() bool {
+	return h.Handler.Empty()
+}
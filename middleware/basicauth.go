@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// users maps a basic-auth username to its expected password, loaded once
+// from a `user:password` credentials file named by a basicauth= tag
+type users map[string]string
+
+// invalidPassword is compared against when a username isn't found, so
+// authenticate always performs a compare and doesn't leak valid usernames
+// through response timing
+const invalidPassword = "\x00"
+
+func newBasicAuth(arg string) (Middleware, error) {
+	if arg == "" {
+		return nil, fmt.Errorf("basicauth requires a credentials file path")
+	}
+
+	creds, err := loadUsers(arg)
+	if err != nil {
+		return nil, err
+	}
+
+	return creds.wrap, nil
+}
+
+func loadUsers(path string) (users, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	creds := make(users)
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		user, pass, has := strings.Cut(line, ":")
+		if !has {
+			return nil, fmt.Errorf("invalid credentials line %q: expected user:password", line)
+		}
+
+		creds[user] = pass
+	}
+
+	return creds, scanner.Err()
+}
+
+// authenticate compares user/pass in constant time, always running a
+// compare even for an unknown user to avoid leaking valid usernames
+func (creds users) authenticate(user, pass string) bool {
+	want, has := creds[user]
+	if !has {
+		want = invalidPassword
+	}
+
+	return subtle.ConstantTimeCompare([]byte(pass), []byte(want)) == 1 && has
+}
+
+func (creds users) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || !creds.authenticate(user, pass) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="nimby"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,15 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/klauspost/compress/gzhttp"
+)
+
+// newGzip builds a Middleware that compresses responses the client accepts
+// gzip encoding for. It takes no argument.
+func newGzip(string) (Middleware, error) {
+	return func(next http.Handler) http.Handler {
+		return gzhttp.GzipHandler(next)
+	}, nil
+}
@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// newHeadersSet builds a Middleware that sets a static response header
+// before the request reaches the domain's balancer, e.g. "X-Frame-Options:DENY"
+func newHeadersSet(arg string) (Middleware, error) {
+	name, value, has := strings.Cut(arg, ":")
+	if !has {
+		return nil, fmt.Errorf("invalid headers-set arg %q: expected Name:Value", arg)
+	}
+
+	name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(name, value)
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
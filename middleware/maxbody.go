@@ -0,0 +1,19 @@
+package middleware
+
+import "net/http"
+
+// newMaxBody builds a Middleware that caps the request body size a domain's
+// balancer will read, e.g. "10MiB"
+func newMaxBody(arg string) (Middleware, error) {
+	limit, err := parseBytes(arg)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
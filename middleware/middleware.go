@@ -0,0 +1,89 @@
+// Package middleware provides a composable per-domain request-processing
+// chain for Nimby: basic-auth, rate limiting, header rewriting, compression,
+// path stripping, and request-body size caps, selected by a service's
+// `nimby-mw:NAME[=ARG]` tags and composed in tag order.
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Middleware wraps an http.Handler to add cross-cutting behavior to a
+// domain's balancer chain
+type Middleware func(http.Handler) http.Handler
+
+// Registry maps a nimby-mw: tag's name to a constructor taking the tag's
+// `=`-delimited argument, which is empty for tags that carry none (e.g. gzip)
+var Registry = map[string]func(arg string) (Middleware, error){
+	"ratelimit":   newRateLimit,
+	"basicauth":   newBasicAuth,
+	"stripprefix": newStripPrefix,
+	"gzip":        newGzip,
+	"maxbody":     newMaxBody,
+	"headers-set": newHeadersSet,
+}
+
+// Resolve builds the ordered Middleware chain named by a domain's
+// nimby-mw: tag values, e.g. ["ratelimit=100rps", "gzip"]. Constructors that
+// hold state (like the rate limiter's per-IP buckets) are built fresh here,
+// so callers should only call Resolve again when a domain's tag set changes.
+func Resolve(values []string) (chain []Middleware, err error) {
+	for _, value := range values {
+		name, arg, _ := strings.Cut(value, "=")
+
+		ctor, has := Registry[name]
+		if !has {
+			return nil, fmt.Errorf("middleware: unknown nimby-mw name %q", name)
+		}
+
+		mw, err := ctor(arg)
+		if err != nil {
+			return nil, fmt.Errorf("middleware: %s: %w", name, err)
+		}
+
+		chain = append(chain, mw)
+	}
+
+	return chain, nil
+}
+
+// Wrap composes a Middleware chain around next, applied in chain order
+func Wrap(chain []Middleware, next http.Handler) http.Handler {
+	handler := next
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		handler = chain[i](handler)
+	}
+
+	return handler
+}
+
+// parseBytes parses a size string with an optional binary-unit suffix
+// (B, KiB, MiB, GiB), e.g. "10MiB"
+func parseBytes(value string) (int64, error) {
+	units := []struct {
+		suffix string
+		scale  int64
+	}{
+		{"GiB", 1 << 30},
+		{"MiB", 1 << 20},
+		{"KiB", 1 << 10},
+		{"B", 1},
+	}
+
+	for _, unit := range units {
+		if digits, has := strings.CutSuffix(value, unit.suffix); has {
+			n, err := strconv.ParseInt(strings.TrimSpace(digits), 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid size %q: %w", value, err)
+			}
+
+			return n * unit.scale, nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid size %q: missing unit suffix", value)
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// ipRateLimiter enforces a requests-per-second budget per client IP, keeping
+// a token-bucket limiter per address seen
+type ipRateLimiter struct {
+	rps   rate.Limit
+	burst int
+
+	mu      sync.Mutex
+	clients map[string]*rate.Limiter
+}
+
+func newRateLimit(arg string) (Middleware, error) {
+	digits, has := strings.CutSuffix(arg, "rps")
+	if !has {
+		return nil, fmt.Errorf("invalid ratelimit %q: expected NrpS, e.g. 100rps", arg)
+	}
+
+	rps, err := strconv.Atoi(strings.TrimSpace(digits))
+	if err != nil || rps <= 0 {
+		return nil, fmt.Errorf("invalid ratelimit %q: %w", arg, err)
+	}
+
+	limiter := &ipRateLimiter{rps: rate.Limit(rps), burst: rps, clients: make(map[string]*rate.Limiter)}
+	return limiter.wrap, nil
+}
+
+// limiterFor returns the token bucket for a client address, creating one on
+// first sight
+func (l *ipRateLimiter) limiterFor(addr string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limiter, has := l.clients[addr]
+	if !has {
+		limiter = rate.NewLimiter(l.rps, l.burst)
+		l.clients[addr] = limiter
+	}
+
+	return limiter
+}
+
+func (l *ipRateLimiter) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.limiterFor(clientIP(r)).Allow() {
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP extracts the client address a rate limiter should key on. The
+// middleware chain runs ahead of WeightedUpstream.rewrite's SetXForwarded, so
+// X-Forwarded-For here is still whatever the client sent and isn't safe to
+// trust; RemoteAddr is the only value a client can't spoof to dodge its bucket.
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+
+	return r.RemoteAddr
+}
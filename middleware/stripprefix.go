@@ -0,0 +1,18 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// newStripPrefix builds a Middleware that strips a leading path prefix
+// before the request reaches the domain's balancer
+func newStripPrefix(arg string) (Middleware, error) {
+	if arg == "" {
+		return nil, fmt.Errorf("stripprefix requires a path prefix argument")
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.StripPrefix(arg, next)
+	}, nil
+}
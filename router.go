@@ -0,0 +1,249 @@
+package nimby
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/jmanero/nimby/logging"
+	"go.uber.org/zap"
+)
+
+// Specificity weights used to order a Router's rules: each predicate adds a
+// flat amount, a PathPrefix additionally scales with its length so the
+// longest prefix wins, and a PathRegexp is penalized so it always loses to
+// any rule built from exact/prefix predicates alone.
+const (
+	specPredicate    = 1
+	specPrefixUnit   = 10
+	specRegexPenalty = -1 << 20
+)
+
+type predicateKind int
+
+const (
+	predicateHost predicateKind = iota
+	predicatePathPrefix
+	predicatePathRegexp
+	predicateMethod
+	predicateHeader
+)
+
+// predicate is a single clause of a nimby-match: expression
+type predicate struct {
+	kind   predicateKind
+	value  string
+	header string
+	re     *regexp.Regexp
+}
+
+func (p predicate) matches(r *http.Request) bool {
+	switch p.kind {
+	case predicateHost:
+		return r.Host == p.value
+	case predicatePathPrefix:
+		return strings.HasPrefix(r.URL.Path, p.value)
+	case predicatePathRegexp:
+		return p.re.MatchString(r.URL.Path)
+	case predicateMethod:
+		return r.Method == p.value
+	case predicateHeader:
+		return r.Header.Get(p.header) == p.value
+	default:
+		return false
+	}
+}
+
+var matchClause = regexp.MustCompile(`^([A-Za-z]+)\((.*)\)$`)
+
+// parseMatch compiles a nimby-match: expression's `&&`-joined clauses into
+// predicates, and derives a specificity score used to order a Router's rules
+func parseMatch(expr string) (predicates []predicate, specificity int, err error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return nil, 0, nil
+	}
+
+	for _, clause := range strings.Split(expr, "&&") {
+		clause = strings.TrimSpace(clause)
+
+		m := matchClause.FindStringSubmatch(clause)
+		if m == nil {
+			return nil, 0, fmt.Errorf("nimby: invalid match clause %q", clause)
+		}
+
+		name, arg := m[1], strings.TrimSpace(m[2])
+		p := predicate{}
+
+		switch name {
+		case "Host":
+			p.kind, p.value = predicateHost, arg
+			specificity += specPredicate
+
+		case "PathPrefix":
+			p.kind, p.value = predicatePathPrefix, arg
+			specificity += specPredicate + len(arg)*specPrefixUnit
+
+		case "PathRegexp":
+			re, rerr := regexp.Compile(arg)
+			if rerr != nil {
+				return nil, 0, fmt.Errorf("nimby: invalid PathRegexp clause %q: %w", clause, rerr)
+			}
+
+			p.kind, p.re = predicatePathRegexp, re
+			specificity += specPredicate + specRegexPenalty
+
+		case "Method":
+			p.kind, p.value = predicateMethod, arg
+			specificity += specPredicate
+
+		case "Header":
+			header, value, has := strings.Cut(arg, ",")
+			if !has {
+				return nil, 0, fmt.Errorf("nimby: invalid Header clause %q", clause)
+			}
+
+			p.kind, p.header, p.value = predicateHeader, strings.TrimSpace(header), strings.TrimSpace(value)
+			specificity += specPredicate
+
+		default:
+			return nil, 0, fmt.Errorf("nimby: unknown match predicate %q", name)
+		}
+
+		predicates = append(predicates, p)
+	}
+
+	return predicates, specificity, nil
+}
+
+// routerRule pairs a compiled nimby-match: expression with the Handler it
+// guards. key is the raw expression text, which Router.Add/Del use to merge
+// or split a service's instances into the same rule across rolling deploys.
+type routerRule struct {
+	key         string
+	predicates  []predicate
+	specificity int
+
+	handler Handler
+}
+
+func (rule *routerRule) matches(r *http.Request) bool {
+	for _, p := range rule.predicates {
+		if !p.matches(r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Router fans a single domain out to different balancers by path, method,
+// and header, on top of the Controller's existing Host-based domain lookup.
+// Rules are tried in priority order; ServeHTTP falls through to 404 when no
+// rule matches the request.
+type Router struct {
+	rules []*routerRule
+}
+
+// newRouter builds an empty Router, for a domain's first Controller.Add
+func newRouter() *Router {
+	return &Router{}
+}
+
+func (router *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, rule := range router.rules {
+		if rule.matches(r) {
+			rule.handler.ServeHTTP(w, r)
+			return
+		}
+	}
+
+	http.NotFound(w, r)
+}
+
+// Add merges a service instance into the rule named by its nimby-match: tag,
+// creating the rule if this is the first instance to use that expression.
+// Router is immutable once stored: Add always returns a new *Router built
+// from a copy of the rule list, so concurrent readers of the prior value
+// never observe a rule under construction.
+func (router *Router) Add(ctx context.Context, service *api.ServiceRegistration) Handler {
+	expr, _ := MatchTag(service.Tags)
+
+	rules := make([]*routerRule, 0, len(router.rules)+1)
+	matched := false
+
+	for _, rule := range router.rules {
+		if rule.key != expr {
+			rules = append(rules, rule)
+			continue
+		}
+
+		rules = append(rules, &routerRule{
+			key:         rule.key,
+			predicates:  rule.predicates,
+			specificity: rule.specificity,
+			handler:     rule.handler.Add(ctx, service),
+		})
+		matched = true
+	}
+
+	if !matched {
+		predicates, specificity, err := parseMatch(expr)
+		if err != nil {
+			_, logger := logging.Logger(ctx)
+			logger.Warn("router.rule.invalid", zap.String("expr", expr), zap.Error(err))
+		} else {
+			rules = append(rules, &routerRule{
+				key:         expr,
+				predicates:  predicates,
+				specificity: specificity,
+				handler:     mwHandler{Handler: NewBalancer(service.Tags)}.Add(ctx, service),
+			})
+		}
+	}
+
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].specificity > rules[j].specificity })
+	return &Router{rules: rules}
+}
+
+// Del removes a service instance from its rule, dropping the rule entirely
+// once its Handler has no upstreams left
+func (router *Router) Del(ctx context.Context, service *api.ServiceRegistration) Handler {
+	expr, _ := MatchTag(service.Tags)
+
+	rules := make([]*routerRule, 0, len(router.rules))
+
+	for _, rule := range router.rules {
+		if rule.key != expr {
+			rules = append(rules, rule)
+			continue
+		}
+
+		handler := rule.handler.Del(ctx, service)
+		if handler.Empty() {
+			continue
+		}
+
+		rules = append(rules, &routerRule{
+			key:         rule.key,
+			predicates:  rule.predicates,
+			specificity: rule.specificity,
+			handler:     handler,
+		})
+	}
+
+	if len(rules) == 0 {
+		return empty{}
+	}
+
+	return &Router{rules: rules}
+}
+
+// Empty reports whether the Router has any rules left
+func (router *Router) Empty() bool {
+	return len(router.rules) == 0
+}
@@ -0,0 +1,122 @@
+// Package tls wires Nimby's live ingress-domain set into automatic TLS
+// certificate issuance, so the proxy never has to be redeployed to pick up a
+// certificate for a newly-registered `nimby-domain:`.
+package tls
+
+import (
+	"context"
+	ctls "crypto/tls"
+	"fmt"
+	"net/http"
+
+	"github.com/jmanero/nimby"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Mode selects how a Manager obtains its certificate material, configured
+// via NIMBY_TLS
+type Mode string
+
+const (
+	ModeOff  Mode = "off"  // no TLS listener
+	ModeACME Mode = "acme" // Let's Encrypt via autocert, gated by the controller's domain set
+	ModeFile Mode = "file" // a static certificate/key pair
+)
+
+// Options configures a Manager
+type Options struct {
+	Mode Mode
+
+	CacheDir string // acme: autocert disk cache directory (NIMBY_TLS_CACHE)
+	Email    string // acme: contact address for Let's Encrypt registration
+
+	CertFile string // file: static certificate path
+	KeyFile  string // file: static key path
+}
+
+// Manager produces a *tls.Config for Nimby's HTTPS listener, either from an
+// autocert.Manager gated by a Controller's live domain set, or from a static
+// certificate/key pair
+type Manager struct {
+	Options
+
+	autocert *autocert.Manager
+	cert     *ctls.Certificate
+}
+
+// New builds a Manager for opts.Mode. In ModeACME, certs are only ever
+// issued for hosts the given Controller currently has registered.
+func New(opts Options, controller *nimby.Controller) (*Manager, error) {
+	manager := &Manager{Options: opts}
+
+	switch opts.Mode {
+	case ModeOff:
+		return manager, nil
+
+	case ModeACME:
+		manager.autocert = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(opts.CacheDir),
+			HostPolicy: hostPolicy(controller),
+			Email:      opts.Email,
+		}
+
+		return manager, nil
+
+	case ModeFile:
+		cert, err := ctls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		manager.cert = &cert
+		return manager, nil
+
+	default:
+		return nil, fmt.Errorf("tls: unknown NIMBY_TLS mode %q", opts.Mode)
+	}
+}
+
+// hostPolicy restricts ACME issuance to domains the controller currently has
+// registered, so Nimby never requests a certificate for an arbitrary SNI
+func hostPolicy(controller *nimby.Controller) autocert.HostPolicy {
+	return func(ctx context.Context, host string) error {
+		if _, has := controller.Get(host); !has {
+			return fmt.Errorf("tls: %q is not a registered ingress domain", host)
+		}
+
+		return nil
+	}
+}
+
+// TLSConfig builds the *tls.Config for Nimby's HTTPS listener
+func (manager *Manager) TLSConfig() *ctls.Config {
+	config := &ctls.Config{NextProtos: []string{"h2", "http/1.1"}}
+
+	switch manager.Mode {
+	case ModeACME:
+		config.GetCertificate = manager.autocert.GetCertificate
+		config.NextProtos = append(config.NextProtos, acme.ALPNProto)
+
+	case ModeFile:
+		config.Certificates = []ctls.Certificate{*manager.cert}
+	}
+
+	return config
+}
+
+// HTTPHandler wraps an HTTP-01 challenge responder around a 301-to-HTTPS
+// fallback, for Nimby's plaintext :80 listener
+func (manager *Manager) HTTPHandler() http.Handler {
+	fallback := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := "https://" + r.Host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+
+	if manager.Mode != ModeACME {
+		return fallback
+	}
+
+	return manager.autocert.HTTPHandler(fallback)
+}